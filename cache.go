@@ -20,6 +20,8 @@ type Cache struct {
 	Region       map[RegionKey]string
 	CityLocation map[uint32]Location
 	CityBlock    BlockList
+	CityBlockV6  BlockListV6
+	ASNBlock     ASNBlockList
 }
 
 type RegionKey struct {
@@ -35,7 +37,40 @@ type Location struct {
 	Latitude,
 	Longitude float32
 	MetroCode,
-	AreaCode string
+	AreaCode,
+	Timezone string
+}
+
+type ASNBlock struct {
+	IpStart,
+	IpEnd uint32
+	ASN          uint
+	Organization string
+}
+
+type ASNBlockList []ASNBlock
+
+func (p ASNBlockList) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
+
+func (p ASNBlockList) Len() int {
+	return len(p)
+}
+
+func (p ASNBlockList) Less(i, j int) bool {
+	return p[i].IpStart < p[j].IpStart
+}
+
+// euCountries is the ISO 3166-1 alpha-2 set of EU member states, used to
+// populate GeoIP.IsEU.
+var euCountries = map[string]bool{
+	"AT": true, "BE": true, "BG": true, "HR": true, "CY": true,
+	"CZ": true, "DK": true, "EE": true, "FI": true, "FR": true,
+	"DE": true, "GR": true, "HU": true, "IE": true, "IT": true,
+	"LV": true, "LT": true, "LU": true, "MT": true, "NL": true,
+	"PL": true, "PT": true, "RO": true, "SK": true, "SI": true,
+	"ES": true, "SE": true,
 }
 
 type Block struct {
@@ -58,6 +93,49 @@ func (p BlockList) Less(i, j int) bool {
 	return p[i].IpStart < p[j].IpStart
 }
 
+// IpV6 holds a 16-byte IPv6 address in big-endian order, suitable for
+// use as a comparable map/slice key.
+type IpV6 [16]byte
+
+func (a IpV6) Less(b IpV6) bool {
+	for n := 0; n < len(a); n++ {
+		if a[n] != b[n] {
+			return a[n] < b[n]
+		}
+	}
+	return false
+}
+
+func (a IpV6) LessOrEqual(b IpV6) bool {
+	return a == b || a.Less(b)
+}
+
+type BlockV6 struct {
+	IpStart,
+	IpEnd IpV6
+	LocId uint32
+}
+
+type BlockListV6 []BlockV6
+
+func (p BlockListV6) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
+
+func (p BlockListV6) Len() int {
+	return len(p)
+}
+
+func (p BlockListV6) Less(i, j int) bool {
+	return p[i].IpStart.Less(p[j].IpStart)
+}
+
+func ipToV6(ip net.IP) IpV6 {
+	var key IpV6
+	copy(key[:], ip.To16())
+	return key
+}
+
 func NewCache(conf *ConfigFile) *Cache {
 	db, err := sql.Open("sqlite3", conf.IPDB.File)
 	if err != nil {
@@ -149,6 +227,7 @@ func NewCache(conf *ConfigFile) *Cache {
 			&loc.Longitude,
 			&loc.MetroCode,
 			&loc.AreaCode,
+			&loc.Timezone,
 		); err != nil {
 			log.Fatal("Failed to load city location from db:", err)
 		}
@@ -176,36 +255,137 @@ func NewCache(conf *ConfigFile) *Cache {
 
 	sort.Sort(cache.CityBlock)
 
+	// Load list of IPv6 city blocks, if the table is present.
+	if row, err = db.Query("SELECT * from city_blocks_v6"); err != nil {
+		log.Println("No city_blocks_v6 table found, IPv6 lookups will be unavailable:", err)
+	} else {
+		var (
+			ipStart, ipEnd []byte
+			bv6            BlockV6
+		)
+
+		for row.Next() {
+			if err = row.Scan(&ipStart, &ipEnd, &bv6.LocId); err != nil {
+				log.Fatal("Failed to load IPv6 city block from db:", err)
+			}
+
+			copy(bv6.IpStart[:], ipStart)
+			copy(bv6.IpEnd[:], ipEnd)
+			cache.CityBlockV6 = append(cache.CityBlockV6, bv6)
+		}
+
+		row.Close()
+
+		sort.Sort(cache.CityBlockV6)
+	}
+
+	// Load list of ASN blocks, if the table is present.
+	if row, err = db.Query("SELECT * from asn_blocks"); err != nil {
+		log.Println("No asn_blocks table found, ASN lookups will be unavailable:", err)
+	} else {
+		var a ASNBlock
+		for row.Next() {
+			if err = row.Scan(&a.IpStart, &a.IpEnd, &a.ASN, &a.Organization); err != nil {
+				log.Fatal("Failed to load ASN block from db:", err)
+			}
+
+			cache.ASNBlock = append(cache.ASNBlock, a)
+		}
+
+		row.Close()
+
+		sort.Sort(cache.ASNBlock)
+	}
+
 	return cache
 }
 
-func (cache *Cache) Query(IP net.IP, nIP uint32) *GeoIP {
-	var reserved bool
-	for _, net := range reservedIPs {
-		if net.Contains(IP) {
-			reserved = true
-			break
-		}
+var _ Reader = (*Cache)(nil)
+
+// RecordMetrics publishes the number of city_blocks, city_blocks_v6 and
+// asn_blocks records to the db_records{table} gauge.
+func (cache *Cache) RecordMetrics(m *MetricRegistry) {
+	m.DBRecords.WithLabelValues("city_blocks").Set(float64(len(cache.CityBlock)))
+	m.DBRecords.WithLabelValues("city_blocks_v6").Set(float64(len(cache.CityBlockV6)))
+	m.DBRecords.WithLabelValues("asn_blocks").Set(float64(len(cache.ASNBlock)))
+}
+
+func (cache *Cache) IsEmpty() bool {
+	return len(cache.CityBlock) == 0 && len(cache.CityBlockV6) == 0
+}
+
+func (cache *Cache) Country(IP net.IP) (countryCode, countryName string) {
+	geoip := cache.City(IP)
+	return geoip.CountryCode, geoip.CountryName
+}
+
+// ASN looks up an IPv4 address in the asn_blocks table, if it was
+// loaded. That table holds IPv4 ranges only, so IPv6 addresses return
+// 0 and "".
+func (cache *Cache) ASN(IP net.IP) (asn uint, org string) {
+	ip4 := IP.To4()
+	if ip4 == nil {
+		return 0, ""
 	}
 
+	geoip := &GeoIP{}
+	nIP := uint32(ip4[0])<<24 | uint32(ip4[1])<<16 | uint32(ip4[2])<<8 | uint32(ip4[3])
+	cache.updateASN(geoip, nIP)
+	return geoip.ASN, geoip.ASNOrganization
+}
+
+func (cache *Cache) City(IP net.IP) *GeoIP {
 	geoip := &GeoIP{Ip: IP.String()}
-	if reserved {
-		geoip.CountryCode = "RD"
-		geoip.CountryName = "Reserved"
+
+	if ip4 := IP.To4(); ip4 != nil {
+		for _, net := range reservedIPs {
+			if net.Contains(ip4) {
+				geoip.CountryCode = "RD"
+				geoip.CountryName = "Reserved"
+				return geoip
+			}
+		}
+
+		nIP := uint32(ip4[0])<<24 | uint32(ip4[1])<<16 | uint32(ip4[2])<<8 | uint32(ip4[3])
+
+		// cache.CityBlock is sorted by IpStart, so find the last block
+		// starting at or before nIP with a binary search instead of
+		// scanning the whole table.
+		n := sort.Search(len(cache.CityBlock), func(i int) bool {
+			return cache.CityBlock[i].IpStart > nIP
+		})
+
+		if n > 0 {
+			n--
+			if nIP <= cache.CityBlock[n].IpEnd {
+				cache.Update(geoip, cache.CityBlock[n].LocId)
+			}
+		}
+
+		cache.updateASN(geoip, nIP)
+
 		return geoip
 	}
 
-	var n int
-	for n = 0; n < len(cache.CityBlock); n++ {
-		if cache.CityBlock[n].IpStart > nIP {
-			break
+	for _, net := range reservedIPsV6 {
+		if net.Contains(IP) {
+			geoip.CountryCode = "RD"
+			geoip.CountryName = "Reserved"
+			return geoip
 		}
 	}
 
+	key := ipToV6(IP)
+
+	// Same binary search as above, over the IPv6 table.
+	n := sort.Search(len(cache.CityBlockV6), func(i int) bool {
+		return key.Less(cache.CityBlockV6[i].IpStart)
+	})
+
 	if n > 0 {
 		n--
-		if nIP <= cache.CityBlock[n].IpEnd {
-			cache.Update(geoip, cache.CityBlock[n].LocId)
+		if key.LessOrEqual(cache.CityBlockV6[n].IpEnd) {
+			cache.Update(geoip, cache.CityBlockV6[n].LocId)
 		}
 	}
 
@@ -233,21 +413,49 @@ func (cache *Cache) Update(geoip *GeoIP, locId uint32) {
 	geoip.Longitude = city.Longitude
 	geoip.MetroCode = city.MetroCode
 	geoip.AreaCode = city.AreaCode
+	geoip.Timezone = city.Timezone
+
+	isEU := euCountries[city.CountryCode]
+	geoip.IsEU = &isEU
+}
+
+// updateASN looks up the ASN block covering nIP and fills it into geoip,
+// if one is loaded. ASN ranges are independent of city/location blocks
+// so this is resolved separately from Update.
+func (cache *Cache) updateASN(geoip *GeoIP, nIP uint32) {
+	n := sort.Search(len(cache.ASNBlock), func(i int) bool {
+		return cache.ASNBlock[i].IpStart > nIP
+	})
+
+	if n == 0 {
+		return
+	}
+	n--
+
+	if nIP <= cache.ASNBlock[n].IpEnd {
+		geoip.ASN = cache.ASNBlock[n].ASN
+		geoip.ASNOrganization = cache.ASNBlock[n].Organization
+	}
 }
 
 type GeoIP struct {
-	XMLName     xml.Name `json:"-" xml:"Response"`
-	Ip          string   `json:"ip"`
-	CountryCode string   `json:"country_code"`
-	CountryName string   `json:"country_name"`
-	RegionCode  string   `json:"region_code"`
-	RegionName  string   `json:"region_name"`
-	CityName    string   `json:"city" xml:"City"`
-	ZipCode     string   `json:"zipcode"`
-	Latitude    float32  `json:"latitude"`
-	Longitude   float32  `json:"longitude"`
-	MetroCode   string   `json:"metro_code"`
-	AreaCode    string   `json:"areacode"`
+	XMLName         xml.Name `json:"-" xml:"Response"`
+	Ip              string   `json:"ip"`
+	CountryCode     string   `json:"country_code"`
+	CountryName     string   `json:"country_name"`
+	RegionCode      string   `json:"region_code"`
+	RegionName      string   `json:"region_name"`
+	CityName        string   `json:"city" xml:"City"`
+	ZipCode         string   `json:"zipcode"`
+	Latitude        float32  `json:"latitude"`
+	Longitude       float32  `json:"longitude"`
+	MetroCode       string   `json:"metro_code"`
+	AreaCode        string   `json:"areacode"`
+	ASN             uint     `json:"asn,omitempty" xml:"ASN,omitempty"`
+	ASNOrganization string   `json:"asn_organization,omitempty" xml:"ASNOrganization,omitempty"`
+	Timezone        string   `json:"time_zone,omitempty" xml:"TimeZone,omitempty"`
+	IsEU            *bool    `json:"is_eu,omitempty" xml:"IsEU,omitempty"`
+	Hostname        string   `json:"hostname,omitempty" xml:"Hostname,omitempty"`
 }
 
 // http://en.wikipedia.org/wiki/Reserved_IP_addresses
@@ -269,3 +477,18 @@ var reservedIPs = []net.IPNet{
 	{net.IPv4(240, 0, 0, 0), net.IPv4Mask(240, 0, 0, 0)},
 	{net.IPv4(255, 255, 255, 255), net.IPv4Mask(255, 255, 255, 255)},
 }
+
+// http://en.wikipedia.org/wiki/Reserved_IP_addresses
+var reservedIPsV6 = []net.IPNet{
+	{net.ParseIP("::"), net.CIDRMask(128, 128)},        // unspecified address
+	{net.ParseIP("::1"), net.CIDRMask(128, 128)},       // loopback
+	{net.ParseIP("::ffff:0:0"), net.CIDRMask(96, 128)}, // IPv4-mapped
+	{net.ParseIP("64:ff9b::"), net.CIDRMask(96, 128)},  // IPv4/IPv6 translation
+	{net.ParseIP("100::"), net.CIDRMask(64, 128)},      // discard-only
+	{net.ParseIP("2001::"), net.CIDRMask(23, 128)},     // IETF protocol assignments
+	{net.ParseIP("2001:db8::"), net.CIDRMask(32, 128)}, // documentation
+	{net.ParseIP("2002::"), net.CIDRMask(16, 128)},     // 6to4
+	{net.ParseIP("fc00::"), net.CIDRMask(7, 128)},      // unique local
+	{net.ParseIP("fe80::"), net.CIDRMask(10, 128)},     // link-local
+	{net.ParseIP("ff00::"), net.CIDRMask(8, 128)},      // multicast
+}
@@ -0,0 +1,64 @@
+// Copyright 2013-2014 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"math/rand"
+	"net"
+	"sort"
+	"testing"
+)
+
+// buildBenchCache returns a Cache populated with n IPv4 and n IPv6
+// blocks, sized like a full GeoLite city block set, to show the
+// binary search scaling to O(log n) instead of the old O(n) scan.
+func buildBenchCache(n int) *Cache {
+	cache := &Cache{
+		CityLocation: map[uint32]Location{1: {CountryCode: "US"}},
+	}
+
+	r := rand.New(rand.NewSource(1))
+
+	start := uint32(0)
+	for i := 0; i < n; i++ {
+		start += uint32(r.Intn(200)) + 1
+		end := start + uint32(r.Intn(100))
+		cache.CityBlock = append(cache.CityBlock, Block{IpStart: start, IpEnd: end, LocId: 1})
+		start = end
+	}
+	sort.Sort(cache.CityBlock)
+
+	var startV6 IpV6
+	for i := 0; i < n; i++ {
+		endV6 := startV6
+		endV6[15] += byte(r.Intn(100))
+		cache.CityBlockV6 = append(cache.CityBlockV6, BlockV6{IpStart: startV6, IpEnd: endV6, LocId: 1})
+		startV6 = endV6
+		startV6[14]++
+	}
+	sort.Sort(cache.CityBlockV6)
+
+	return cache
+}
+
+func BenchmarkCityV4(b *testing.B) {
+	cache := buildBenchCache(300000)
+	ip := net.IPv4(8, 8, 8, 8)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.City(ip)
+	}
+}
+
+func BenchmarkCityV6(b *testing.B) {
+	cache := buildBenchCache(300000)
+	ip := net.ParseIP("2001:4860:4860::8888")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.City(ip)
+	}
+}
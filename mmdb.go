@@ -0,0 +1,94 @@
+// Copyright 2013-2014 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"net"
+	"path/filepath"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// MMDBReader is a Reader backed by MaxMind's GeoLite2 .mmdb databases.
+// Unlike Cache it keeps the databases on disk and resolves each lookup
+// with the tree search built into the mmdb format, so it avoids the
+// multi-hundred-megabyte in-memory load Cache requires.
+type MMDBReader struct {
+	city    *geoip2.Reader
+	country *geoip2.Reader
+	asn     *geoip2.Reader
+}
+
+var _ Reader = (*MMDBReader)(nil)
+
+// NewMMDBReader opens GeoLite2-City.mmdb, GeoLite2-Country.mmdb and
+// GeoLite2-ASN.mmdb from conf.IPDB.MMDBDir.
+func NewMMDBReader(conf *ConfigFile) *MMDBReader {
+	dir := conf.IPDB.MMDBDir
+
+	city, err := geoip2.Open(filepath.Join(dir, "GeoLite2-City.mmdb"))
+	if err != nil {
+		log.Fatal("Failed to open GeoLite2-City.mmdb:", err)
+	}
+
+	country, err := geoip2.Open(filepath.Join(dir, "GeoLite2-Country.mmdb"))
+	if err != nil {
+		log.Fatal("Failed to open GeoLite2-Country.mmdb:", err)
+	}
+
+	asn, err := geoip2.Open(filepath.Join(dir, "GeoLite2-ASN.mmdb"))
+	if err != nil {
+		log.Fatal("Failed to open GeoLite2-ASN.mmdb:", err)
+	}
+
+	return &MMDBReader{city: city, country: country, asn: asn}
+}
+
+func (r *MMDBReader) IsEmpty() bool {
+	return r == nil
+}
+
+func (r *MMDBReader) Country(ip net.IP) (countryCode, countryName string) {
+	rec, err := r.country.Country(ip)
+	if err != nil {
+		return "", ""
+	}
+
+	return rec.Country.IsoCode, rec.Country.Names["en"]
+}
+
+func (r *MMDBReader) City(ip net.IP) *GeoIP {
+	geoip := &GeoIP{Ip: ip.String()}
+
+	rec, err := r.city.City(ip)
+	if err != nil {
+		return geoip
+	}
+
+	geoip.CountryCode = rec.Country.IsoCode
+	geoip.CountryName = rec.Country.Names["en"]
+
+	if len(rec.Subdivisions) > 0 {
+		geoip.RegionCode = rec.Subdivisions[0].IsoCode
+		geoip.RegionName = rec.Subdivisions[0].Names["en"]
+	}
+
+	geoip.CityName = rec.City.Names["en"]
+	geoip.ZipCode = rec.Postal.Code
+	geoip.Latitude = float32(rec.Location.Latitude)
+	geoip.Longitude = float32(rec.Location.Longitude)
+
+	return geoip
+}
+
+func (r *MMDBReader) ASN(ip net.IP) (asn uint, org string) {
+	rec, err := r.asn.ASN(ip)
+	if err != nil {
+		return 0, ""
+	}
+
+	return uint(rec.AutonomousSystemNumber), rec.AutonomousSystemOrganization
+}
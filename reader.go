@@ -0,0 +1,32 @@
+// Copyright 2013-2014 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+)
+
+// Reader is implemented by every geo backend this server can serve
+// lookups from. It follows the shape used across the echoip/geodns
+// family of tools so backends can be swapped without touching the
+// HTTP handlers.
+type Reader interface {
+	Country(ip net.IP) (countryCode, countryName string)
+	City(ip net.IP) *GeoIP
+	ASN(ip net.IP) (asn uint, org string)
+	IsEmpty() bool
+}
+
+// NewReader builds the Reader configured by conf.IPDB.Driver. It
+// defaults to the SQLite-backed Cache for backward compatibility with
+// existing freegeoip.sqlite deployments.
+func NewReader(conf *ConfigFile) Reader {
+	switch conf.IPDB.Driver {
+	case "mmdb":
+		return NewMMDBReader(conf)
+	default:
+		return NewCache(conf)
+	}
+}
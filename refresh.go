@@ -0,0 +1,195 @@
+// Copyright 2013-2014 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Updater periodically downloads the newest MaxMind database, builds a
+// fresh Reader from it and swaps it in for the one being served, so
+// in-flight lookups keep using the old Reader until they complete.
+type Updater struct {
+	conf    *ConfigFile
+	metrics *MetricRegistry
+
+	mu           sync.RWMutex
+	reader       Reader
+	lastRefresh  time.Time
+	nextRefresh  time.Time
+	dbBuildEpoch int64
+}
+
+// NewUpdater wraps an already loaded Reader. It does not start any
+// refreshing itself; call Run to refresh it every
+// conf.IPDB.RefreshInterval.
+func NewUpdater(conf *ConfigFile, reader Reader, metrics *MetricRegistry) *Updater {
+	u := &Updater{
+		conf:        conf,
+		metrics:     metrics,
+		reader:      reader,
+		lastRefresh: time.Now(),
+	}
+	u.nextRefresh = u.lastRefresh.Add(conf.IPDB.RefreshInterval)
+	return u
+}
+
+// Reader returns the Reader currently in use. It's safe to call while a
+// refresh is swapping it out.
+func (u *Updater) Reader() Reader {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.reader
+}
+
+// Run starts the refresh loop, pulling once every
+// conf.IPDB.RefreshInterval. It returns at once if that interval is not
+// positive and otherwise never returns, so callers should invoke it in a
+// goroutine.
+func (u *Updater) Run() {
+	if u.conf.IPDB.RefreshInterval <= 0 {
+		return
+	}
+
+	for {
+		time.Sleep(u.conf.IPDB.RefreshInterval)
+
+		if err := u.refresh(); err != nil {
+			log.Println("Failed to refresh ip database:", err)
+			u.metrics.DBRefreshFailure.Inc()
+			continue
+		}
+
+		u.metrics.DBRefreshSuccess.Inc()
+	}
+}
+
+// refresh downloads the latest database file, verifies it against its
+// SHA256 sidecar, atomically swaps it onto conf.IPDB.File, builds a fresh
+// Reader with NewReader and swaps the process-wide pointer. With the mmdb
+// driver the fresh Reader opens conf.IPDB.MMDBDir, which the download
+// does not write to.
+func (u *Updater) refresh() error {
+	tmp, sum, err := u.download()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err = verifySHA256(tmp, sum); err != nil {
+		return err
+	}
+
+	dst := u.conf.IPDB.File
+	if err = os.Rename(tmp, dst); err != nil {
+		return err
+	}
+
+	reader := NewReader(u.conf)
+	if cache, ok := reader.(*Cache); ok {
+		cache.RecordMetrics(u.metrics)
+	}
+	u.metrics.DBBuildTimestamp.Set(float64(time.Now().Unix()))
+
+	u.mu.Lock()
+	u.reader = reader
+	u.lastRefresh = time.Now()
+	u.nextRefresh = u.lastRefresh.Add(u.conf.IPDB.RefreshInterval)
+	u.dbBuildEpoch = u.lastRefresh.Unix()
+	u.mu.Unlock()
+
+	return nil
+}
+
+// download fetches the configured MaxMind URL into a temporary file
+// next to the live database and returns its path along with the
+// expected SHA256 sum from the ".sha256" sidecar.
+func (u *Updater) download() (tmpPath, sum string, err error) {
+	url := fmt.Sprintf("%s?license_key=%s", u.conf.IPDB.DownloadURL, u.conf.IPDB.LicenseKey)
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("download failed with status %s", resp.Status)
+	}
+
+	sumURL := fmt.Sprintf("%s.sha256?license_key=%s", u.conf.IPDB.DownloadURL, u.conf.IPDB.LicenseKey)
+	sumResp, err := http.Get(sumURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer sumResp.Body.Close()
+
+	sumBytes, err := io.ReadAll(sumResp.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	// The sidecar may also carry a file name after the sum.
+	sumFields := strings.Fields(string(sumBytes))
+	if len(sumFields) == 0 {
+		return "", "", fmt.Errorf("empty sha256 sidecar")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(u.conf.IPDB.File), "freegeoip-db-")
+	if err != nil {
+		return "", "", err
+	}
+	defer tmp.Close()
+
+	if _, err = io.Copy(tmp, resp.Body); err != nil {
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+
+	return tmp.Name(), sumFields[0], nil
+}
+
+func verifySHA256(path, want string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return err
+	}
+
+	got := hex.EncodeToString(h.Sum(nil))
+	if got != want {
+		return fmt.Errorf("sha256 mismatch: got %s, want %s", got, want)
+	}
+
+	return nil
+}
+
+// Status returns the fields exposed on /status about the refresh
+// subsystem: last_refresh, next_refresh and db_build_epoch.
+func (u *Updater) Status() map[string]interface{} {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	return map[string]interface{}{
+		"last_refresh":   u.lastRefresh,
+		"next_refresh":   u.nextRefresh,
+		"db_build_epoch": u.dbBuildEpoch,
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2013-2014 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// ReverseResolver resolves the reverse DNS hostname for an IP with
+// bounded concurrency and an LRU cache, so opting into it per request
+// (e.g. a "?reverse=1" query flag) can't exhaust file descriptors or
+// stall the server behind a slow or unresponsive resolver.
+type ReverseResolver struct {
+	sem     chan struct{}
+	timeout time.Duration
+
+	mu       sync.Mutex
+	order    *list.List
+	byIP     map[string]*list.Element
+	capacity int
+}
+
+type reverseEntry struct {
+	ip   string
+	host string
+}
+
+// NewReverseResolver returns a resolver that allows at most concurrency
+// lookups in flight at once, caches up to capacity results, and gives up
+// on a lookup after timeout.
+func NewReverseResolver(concurrency, capacity int, timeout time.Duration) *ReverseResolver {
+	return &ReverseResolver{
+		sem:      make(chan struct{}, concurrency),
+		timeout:  timeout,
+		order:    list.New(),
+		byIP:     make(map[string]*list.Element),
+		capacity: capacity,
+	}
+}
+
+// Lookup returns the first reverse DNS hostname for ip, or "" if none
+// was found or the lookup timed out.
+func (r *ReverseResolver) Lookup(ip net.IP) string {
+	key := ip.String()
+
+	if host, ok := r.cached(key); ok {
+		return host
+	}
+
+	r.sem <- struct{}{}
+	defer func() { <-r.sem }()
+
+	// Another goroutine may have populated the cache while we waited
+	// for a slot.
+	if host, ok := r.cached(key); ok {
+		return host
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	var host string
+	if names, err := net.DefaultResolver.LookupAddr(ctx, key); err == nil && len(names) > 0 {
+		host = names[0]
+	}
+
+	r.store(key, host)
+	return host
+}
+
+func (r *ReverseResolver) cached(key string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	el, ok := r.byIP[key]
+	if !ok {
+		return "", false
+	}
+
+	r.order.MoveToFront(el)
+	return el.Value.(*reverseEntry).host, true
+}
+
+func (r *ReverseResolver) store(key, host string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	el := r.order.PushFront(&reverseEntry{ip: key, host: host})
+	r.byIP[key] = el
+
+	if r.order.Len() > r.capacity {
+		oldest := r.order.Back()
+		if oldest != nil {
+			r.order.Remove(oldest)
+			delete(r.byIP, oldest.Value.(*reverseEntry).ip)
+		}
+	}
+}
@@ -1,63 +1,134 @@
+// Copyright 2013-2014 Alexandre Fiori
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
 package main
 
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// MetricRegistry replaces the old *int counter map, which raced with
+// json.Marshal and did unsynchronized increments, with a set of
+// Prometheus collectors served in the standard text format at /metrics.
+// The legacy /status JSON payload is kept for backward compatibility,
+// but its numbers are read back out of these same collectors.
 type MetricRegistry struct {
-	Appname string          `json:"appname"`
-	Node    string          `json:"node"`
-	Metrics map[string]*int `json:"metrics"`
+	Appname string
+	Node    string
+
+	Registry *prometheus.Registry
+
+	LookupsTotal     *prometheus.CounterVec
+	LookupDuration   prometheus.Histogram
+	DBRecords        *prometheus.GaugeVec
+	DBBuildTimestamp prometheus.Gauge
+	DBRefreshSuccess prometheus.Counter
+	DBRefreshFailure prometheus.Counter
 }
 
 func NewMetricRegistry(appname string) *MetricRegistry {
-	m := MetricRegistry{}
-	m.Appname = appname
-	m.Metrics = make(map[string]*int)
+	m := &MetricRegistry{
+		Appname:  appname,
+		Registry: prometheus.NewRegistry(),
+
+		LookupsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "lookups_total",
+			Help: "Total number of geoip lookups, labelled by status and resolved country.",
+		}, []string{"status", "country"}),
+
+		LookupDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "lookup_duration_seconds",
+			Help: "Latency of geoip lookups, in seconds.",
+		}),
+
+		DBRecords: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "db_records",
+			Help: "Number of records loaded per database table.",
+		}, []string{"table"}),
+
+		DBBuildTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "db_build_timestamp_seconds",
+			Help: "Unix timestamp of the currently loaded database build.",
+		}),
+
+		DBRefreshSuccess: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "db_refresh_success_total",
+			Help: "Number of successful database auto-refresh cycles.",
+		}),
+
+		DBRefreshFailure: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "db_refresh_failure_total",
+			Help: "Number of failed database auto-refresh attempts.",
+		}),
+	}
+
 	host, _ := os.Hostname()
 	m.Node = fmt.Sprintf("%s:%s:%d", appname, host, os.Getpid())
-	return &m
-}
 
-func (m MetricRegistry) String() (s string) {
-	b, err := json.Marshal(m)
-	if err != nil {
-		s = ""
-		return
-	}
-	s = string(b)
-	return
+	m.Registry.MustRegister(
+		m.LookupsTotal,
+		m.LookupDuration,
+		m.DBRecords,
+		m.DBBuildTimestamp,
+		m.DBRefreshSuccess,
+		m.DBRefreshFailure,
+	)
+
+	return m
 }
 
-func (m MetricRegistry) NewCounter(name string) *int {
-	c := 0
-	m.Metrics[name] = &c
-	return &c
+// Handler serves the Prometheus text exposition format at /metrics.
+func (m *MetricRegistry) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
 }
 
-// Incr, Decr, Get, Reset
-func (m MetricRegistry) Incr(name string) {
-	*(m.GetCounter(name))++
+// RecordLookup records the outcome and latency of a single geoip lookup.
+func (m *MetricRegistry) RecordLookup(status, country string, duration time.Duration) {
+	m.LookupsTotal.WithLabelValues(status, country).Inc()
+	m.LookupDuration.Observe(duration.Seconds())
 }
 
-func (m MetricRegistry) Decr(name string) {
-	*(m.GetCounter(name))--
+// Status returns the legacy /status JSON payload.
+func (m MetricRegistry) Status() map[string]interface{} {
+	return map[string]interface{}{
+		"appname":            m.Appname,
+		"node":               m.Node,
+		"db_refresh_success": counterValue(m.DBRefreshSuccess),
+		"db_refresh_failure": counterValue(m.DBRefreshFailure),
+		"db_build_epoch":     gaugeValue(m.DBBuildTimestamp),
+	}
 }
 
-func (m MetricRegistry) Get(name string) int {
-	return *(m.GetCounter(name))
+func (m MetricRegistry) String() (s string) {
+	b, err := json.Marshal(m.Status())
+	if err != nil {
+		return ""
+	}
+	return string(b)
 }
 
-func (m MetricRegistry) Reset(name string) {
-	*(m.GetCounter(name)) = 0
+func counterValue(c prometheus.Counter) float64 {
+	var pb dto.Metric
+	if err := c.Write(&pb); err != nil {
+		return 0
+	}
+	return pb.GetCounter().GetValue()
 }
 
-func (m MetricRegistry) GetCounter(name string) *int {
-	if c := m.Metrics[name]; c != nil {
-		return c
-	} else {
-		return m.NewCounter(name)
+func gaugeValue(g prometheus.Gauge) float64 {
+	var pb dto.Metric
+	if err := g.Write(&pb); err != nil {
+		return 0
 	}
+	return pb.GetGauge().GetValue()
 }